@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	EventLogin        = "login"
+	EventTokenRefresh = "token_refresh"
+	EventLogout       = "logout"
+	EventMFAChallenge = "mfa_challenge"
+	EventLoginFailed  = "login_failed"
+)
+
+// LoginAuditEntry is one row of bo_login_audit: an authentication-related event, as opposed to the
+// per-request "last access" activity tracked by Session/bo_active_tokens.
+type LoginAuditEntry struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	EventType     string    `json:"event_type"`
+	TokenJTI      string    `json:"token_jti"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failure_reason"`
+	At            time.Time `json:"at"`
+}
+
+// RecordLoginEvent appends an entry to bo_login_audit. It is deliberately a plain, synchronous
+// insert (unlike the throttled session access writes below) because audit events are comparatively
+// rare and must not be dropped. A successful EventLogin also updates bo_user.last_login — this is
+// now the only place that column is written; User.Get no longer touches it on every lookup.
+func RecordLoginEvent(userID int, eventType, tokenJTI, ip, userAgent string, success bool, failureReason string, db *sql.DB) error {
+	sqlS := "INSERT INTO bo_login_audit (user_id, event_type, token_jti, ip, user_agent, success, failure_reason, at) VALUES (?,?,?,?,?,?,?,NOW())"
+	_, err := db.Exec(sqlS, userID, eventType, tokenJTI, ip, userAgent, success, failureReason)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	if eventType == EventLogin && success {
+		_, err = db.Exec("UPDATE bo_user SET last_login=NOW() WHERE id=?", userID)
+		if err != nil {
+			return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// GetLoginHistory returns a user's most recent login audit entries, newest first, for a "recent
+// activity" screen.
+func GetLoginHistory(userID int, limit int, db *sql.DB) ([]LoginAuditEntry, error) {
+	entries := make([]LoginAuditEntry, 0)
+
+	sqlS := "SELECT id, user_id, event_type, token_jti, ip, user_agent, success, failure_reason, at FROM bo_login_audit WHERE user_id=? ORDER BY at DESC LIMIT ?"
+	rows, err := db.Query(sqlS, userID, limit)
+	if err != nil {
+		return entries, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		e := LoginAuditEntry{}
+		if err = rows.Scan(&e.ID, &e.UserID, &e.EventType, &e.TokenJTI, &e.IP, &e.UserAgent, &e.Success, &e.FailureReason, &e.At); err != nil {
+			return entries, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Session is one row of bo_active_tokens: a currently valid access token, as tracked for the
+// "active sessions" admin/user view.
+type Session struct {
+	JTI        string    `json:"jti"`
+	UserID     int       `json:"user_id"`
+	IssuedAt   time.Time `json:"issued_at"`
+	LastAccess time.Time `json:"last_access"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+}
+
+// ListSessions returns every currently valid session for a user, as tracked in bo_active_tokens.
+func ListSessions(userID int, db *sql.DB) ([]Session, error) {
+	sessions := make([]Session, 0)
+
+	sqlS := "SELECT jti, user_id, issued_at, last_access, user_agent, ip FROM bo_active_tokens WHERE user_id=?"
+	rows, err := db.Query(sqlS, userID)
+	if err != nil {
+		return sessions, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		s := Session{}
+		if err = rows.Scan(&s.JTI, &s.UserID, &s.IssuedAt, &s.LastAccess, &s.UserAgent, &s.IP); err != nil {
+			return sessions, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends a single session: it blacklists the token's jti (so VerifyToken starts
+// rejecting it immediately) and removes it from bo_active_tokens.
+func RevokeSession(jti string, db *sql.DB) error {
+	if err := RevokeToken(jti, db); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("DELETE FROM bo_active_tokens WHERE jti=?", jti)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return nil
+}
+
+// SessionFlusher batches bo_active_tokens.last_access updates in memory and writes them to the
+// database on a timer or once the pending batch grows past sizeThreshold, rather than issuing an
+// UPDATE on every single authenticated request. Touch is safe for concurrent use.
+type SessionFlusher struct {
+	db            *sql.DB
+	interval      time.Duration
+	sizeThreshold int
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSessionFlusher creates a flusher that writes buffered last_access updates every interval, or
+// immediately once sizeThreshold distinct tokens are pending, whichever comes first.
+func NewSessionFlusher(db *sql.DB, interval time.Duration, sizeThreshold int) *SessionFlusher {
+	return &SessionFlusher{
+		db:            db,
+		interval:      interval,
+		sizeThreshold: sizeThreshold,
+		pending:       make(map[string]time.Time),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Start runs the flusher's background goroutine until Stop is called.
+func (f *SessionFlusher) Start() {
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		defer close(f.done)
+
+		for {
+			select {
+			case <-ticker.C:
+				f.flush()
+			case <-f.stop:
+				f.flush()
+				return
+			}
+		}
+	}()
+}
+
+// Stop flushes any remaining pending updates and waits for the background goroutine to exit.
+func (f *SessionFlusher) Stop() {
+	close(f.stop)
+	<-f.done
+}
+
+// Touch records that jti was used at t, to be written on the next flush. Calling Touch many times
+// for the same jti between flushes only ever results in one UPDATE, so this is naturally throttled
+// to once per flush interval per token regardless of request volume.
+func (f *SessionFlusher) Touch(jti string, t time.Time) {
+	f.mu.Lock()
+	f.pending[jti] = t
+	shouldFlush := len(f.pending) >= f.sizeThreshold
+	f.mu.Unlock()
+
+	if shouldFlush {
+		f.flush()
+	}
+}
+
+func (f *SessionFlusher) flush() {
+	f.mu.Lock()
+	if len(f.pending) == 0 {
+		f.mu.Unlock()
+		return
+	}
+	batch := f.pending
+	f.pending = make(map[string]time.Time)
+	f.mu.Unlock()
+
+	for jti, t := range batch {
+		_, err := f.db.Exec("UPDATE bo_active_tokens SET last_access=? WHERE jti=?", t, jti)
+		if err != nil {
+			log.Warnln(err)
+		}
+	}
+}
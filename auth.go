@@ -1,16 +1,24 @@
 package auth
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/golang-jwt/jwt"
-	"os"
+	"sync"
 	"time"
 )
 
+// AccessTokenTTL is the lifetime of a JWT issued by CreateToken/CreateTokenPair. It is kept short
+// because the refresh-token flow (CreateTokenPair/RefreshToken) is what carries a session forward;
+// a long-lived access token would defeat the point of being able to revoke a session server-side.
+const AccessTokenTTL = 15 * time.Minute
+
 // CreateToken generates a JSON Web Token (JWT) using the provided user token.
 // It takes the user token as input and returns the generated token string and an error, if any.
-// The function uses the secret key retrieved from the environment variable SECRET_KEY for signing the token.
-// The token is set to expire after 24 hours from the current time.
+// The token is signed with the active key of the package-level KeyStore (HS256 against SECRET_KEY
+// by default, or RS256/EdDSA if SetKeyStore has been called) and carries a "kid" header identifying
+// which key to verify it with, plus a unique "jti" claim so it can later be revoked via RevokeToken.
+// The token is set to expire after AccessTokenTTL from the current time.
 // If there is an error during token creation, an empty string and the error are returned.
 //
 // Example usage:
@@ -23,29 +31,53 @@ import (
 //	    fmt.Println("Token:", tokenString)
 //	}
 func CreateToken(usertkn string) (string, error) {
-	var secretKey = []byte(os.Getenv("SECRET_KEY"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
+	tokenString, _, err := createAccessToken(usertkn, false)
+	return tokenString, err
+}
+
+// CreateTokenWithMFA is CreateToken with an explicit "mfa" claim recording whether the holder has
+// passed a second verification step. Middleware like RequireMFA should be applied to sensitive
+// endpoints and check this claim rather than trusting a token minted before MFA was satisfied.
+func CreateTokenWithMFA(usertkn string, mfaSatisfied bool) (string, error) {
+	tokenString, _, err := createAccessToken(usertkn, mfaSatisfied)
+	return tokenString, err
+}
+
+// createAccessToken is the shared implementation behind CreateToken/CreateTokenWithMFA; it also
+// returns the token's "jti" so callers that need to track the issued token (e.g. CreateTokenPair
+// recording it in bo_active_tokens) don't have to re-parse the JWT they just signed.
+func createAccessToken(usertkn string, mfaSatisfied bool) (tokenString, jti string, err error) {
+	key, err := keyStore().ActiveKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	jti = newKID()
+	token := jwt.NewWithClaims(key.signingMethod(),
 		jwt.MapClaims{
 			"usertkn": usertkn,
-			"exp":     time.Now().Add(time.Hour * 24).Unix(),
+			"jti":     jti,
+			"mfa":     mfaSatisfied,
+			"exp":     time.Now().Add(AccessTokenTTL).Unix(),
 		})
+	token.Header["kid"] = key.KID
 
-	tokenString, err := token.SignedString(secretKey)
+	tokenString, err = token.SignedString(key.SigningKey)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return tokenString, nil
+	return tokenString, jti, nil
 }
 
 // VerifyToken verifies the authenticity of a JSON Web Token (JWT) using the provided token string.
 // It takes the token string as input and returns the user token and an error, if any.
-// The function uses the secret key retrieved from the environment variable SECRET_KEY for token verification.
-// If the token cannot be parsed or is invalid, an error is returned.
 // If the token is valid, the user token is extracted from the token claims and returned.
+// See VerifyTokenClaims for the full set of checks performed and for access to the raw claims (e.g.
+// to feed RequireMFA).
 //
 // Example usage:
-// usertkn, err := VerifyToken(tokenString)
+// usertkn, err := VerifyToken(tokenString, db)
 //
 //	if err != nil {
 //	    fmt.Println("Error verifying token:", err)
@@ -53,23 +85,91 @@ func CreateToken(usertkn string) (string, error) {
 //
 //	    fmt.Println("User Token:", usertkn)
 //	}
-func VerifyToken(tokenString string) (usertkn string, err error) {
-	var secretKey = []byte(os.Getenv("SECRET_KEY"))
+func VerifyToken(tokenString string, db *sql.DB) (usertkn string, err error) {
+	claims, err := VerifyTokenClaims(tokenString, db)
+	if err != nil {
+		return "", err
+	}
+
+	usertkn, _ = claims["usertkn"].(string)
+
+	return usertkn, nil
+}
+
+// VerifyTokenClaims verifies the authenticity of tokenString and returns its claims.
+// The key used to check the signature is looked up in the package-level KeyStore by the token's
+// "kid" header, so tokens remain verifiable against any key that has not yet left its rotation
+// grace period, not just the currently active signing key.
+// If the token cannot be parsed or is invalid, an error is returned.
+// If the token's "jti" has been blacklisted (e.g. because the session was logged out or the refresh
+// chain was revoked), ErrTokenRevoked is returned even though the JWT signature and expiry are still valid.
+// On success, the token's last_access in bo_active_tokens is queued for an update via the
+// package-level SessionFlusher (see StartSessionFlusher), and the returned claims can be passed
+// straight to RequireMFA.
+func VerifyTokenClaims(tokenString string, db *sql.DB) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
+		kid, _ := token.Header["kid"].(string)
+		key, err := keyStore().Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.VerifyKey, nil
 	})
 
 	if err != nil {
-		return usertkn, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return usertkn, fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
 	claims := token.Claims.(jwt.MapClaims)
 
-	usertkn = claims["usertkn"].(string)
+	if err = claims.Valid(); err != nil {
+		return nil, err
+	}
 
-	return usertkn, nil
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		blacklisted, err := isTokenBlacklisted(jti, db)
+		if err != nil {
+			return nil, err
+		}
+		if blacklisted {
+			return nil, ErrTokenRevoked
+		}
+		touchSession(jti)
+	}
+
+	return claims, nil
+}
+
+// activeSessionFlusher is the package-level SessionFlusher used to throttle bo_active_tokens.last_access
+// writes; it is nil until StartSessionFlusher is called, in which case touchSession is a no-op.
+var (
+	activeSessionFlusherMu sync.RWMutex
+	activeSessionFlusher   *SessionFlusher
+)
+
+// StartSessionFlusher installs and starts the package-level SessionFlusher that VerifyTokenClaims
+// reports token usage to. Call it once at startup with the application's *sql.DB.
+func StartSessionFlusher(db *sql.DB, interval time.Duration, sizeThreshold int) *SessionFlusher {
+	f := NewSessionFlusher(db, interval, sizeThreshold)
+	f.Start()
+
+	activeSessionFlusherMu.Lock()
+	activeSessionFlusher = f
+	activeSessionFlusherMu.Unlock()
+
+	return f
+}
+
+func touchSession(jti string) {
+	activeSessionFlusherMu.RLock()
+	f := activeSessionFlusher
+	activeSessionFlusherMu.RUnlock()
+
+	if f != nil {
+		f.Touch(jti, time.Now())
+	}
 }
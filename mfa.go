@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrMFAAlreadyEnabled = errors.New("MFA is already enrolled for this user")
+var ErrInvalidTOTPCode = errors.New("invalid or expired TOTP code")
+var ErrInvalidRecoveryCode = errors.New("invalid or already-used recovery code")
+var ErrMFARequired = errors.New("this action requires a second factor")
+
+const (
+	totpIssuer        = "Tap2Park"
+	totpPeriod        = 30
+	totpDigits        = otp.DigitsSix
+	totpAlg           = otp.AlgorithmSHA1
+	totpStepSkew      = 1
+	recoveryCodeCount = 10
+)
+
+// EnrollTOTP generates a new RFC 6238 TOTP secret for user, stores it (encrypted) in bo_user_mfa
+// pending confirmation, and returns the secret, a QR code PNG of the provisioning URI, and a set of
+// one-time recovery codes. The recovery codes are only ever returned here; only their hashes are
+// stored. Enrollment is not active until VerifyTOTP succeeds and confirms it.
+func EnrollTOTP(user *User, db *sql.DB) (secret string, qrPNG []byte, recovery []string, err error) {
+	var exists int
+	err = db.QueryRow("SELECT 1 FROM bo_user_mfa WHERE user_id=? AND enabled=1", user.ID).Scan(&exists)
+	if err == nil {
+		return "", nil, nil, ErrMFAAlreadyEnabled
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", nil, nil, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Email,
+		Period:      totpPeriod,
+		SecretSize:  20,
+		Digits:      totpDigits,
+		Algorithm:   totpAlg,
+	})
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return "", nil, nil, err
+	}
+
+	secret = key.Secret()
+	encSecret, err := encryptMFASecret(secret)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	recovery, hashedRecovery, err := generateRecoveryCodes()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	sqlS := "INSERT INTO bo_user_mfa (user_id, secret_enc, enabled, algorithm, digits, period, recovery_codes) VALUES (?,?,0,?,?,?,?)"
+	_, err = db.Exec(sqlS, user.ID, encSecret, totpAlg.String(), int(totpDigits), totpPeriod, joinHashes(hashedRecovery))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return secret, buf.Bytes(), recovery, nil
+}
+
+// VerifyTOTP checks code against user's enrolled TOTP secret, accepting codes within a ±1 step
+// (30s) window and rejecting a step that has already been consumed to prevent replay. On the first
+// successful verification after EnrollTOTP, this also confirms the enrollment (sets enabled=1).
+func VerifyTOTP(user *User, code string, db *sql.DB) error {
+	var encSecret string
+	var lastUsedStep int64
+	var confirmed sql.NullTime
+
+	sqlS := "SELECT secret_enc, last_used_step, confirmed_at FROM bo_user_mfa WHERE user_id=?"
+	err := db.QueryRow(sqlS, user.ID).Scan(&encSecret, &lastUsedStep, &confirmed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidTOTPCode
+		}
+		return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	secret, err := decryptMFASecret(encSecret)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	currentStep := now.Unix() / totpPeriod
+
+	for skew := int64(-totpStepSkew); skew <= totpStepSkew; skew++ {
+		step := currentStep + skew
+		if step <= lastUsedStep {
+			continue
+		}
+
+		candidate, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    totpDigits,
+			Algorithm: totpAlg,
+		})
+		if err != nil {
+			return err
+		}
+
+		if candidate == code {
+			_, err = db.Exec("UPDATE bo_user_mfa SET last_used_step=?, enabled=1, confirmed_at=COALESCE(confirmed_at,NOW()) WHERE user_id=?", step, user.ID)
+			if err != nil {
+				return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+			}
+			if err = RecordLoginEvent(user.ID, EventMFAChallenge, "", "", "", true, "", db); err != nil {
+				log.Warnln(err)
+			}
+			return nil
+		}
+	}
+
+	if err = RecordLoginEvent(user.ID, EventMFAChallenge, "", "", "", false, "invalid TOTP code", db); err != nil {
+		log.Warnln(err)
+	}
+	return ErrInvalidTOTPCode
+}
+
+// VerifyRecoveryCode consumes one of user's one-time recovery codes as a stand-in for VerifyTOTP,
+// e.g. when they have lost their TOTP device. Each code is single-use: on success it is removed
+// from bo_user_mfa.recovery_codes so it cannot be presented again.
+func VerifyRecoveryCode(user *User, code string, db *sql.DB) error {
+	var stored string
+	err := db.QueryRow("SELECT recovery_codes FROM bo_user_mfa WHERE user_id=?", user.ID).Scan(&stored)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRecoveryCode
+		}
+		return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	hash := hashToken(code)
+	hashes := splitHashes(stored)
+
+	remaining := make([]string, 0, len(hashes))
+	found := false
+	for _, h := range hashes {
+		if h == hash && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+
+	if !found {
+		if err = RecordLoginEvent(user.ID, EventMFAChallenge, "", "", "", false, "invalid recovery code", db); err != nil {
+			log.Warnln(err)
+		}
+		return ErrInvalidRecoveryCode
+	}
+
+	_, err = db.Exec("UPDATE bo_user_mfa SET recovery_codes=? WHERE user_id=?", joinHashes(remaining), user.ID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	if err = RecordLoginEvent(user.ID, EventMFAChallenge, "", "", "", true, "", db); err != nil {
+		log.Warnln(err)
+	}
+
+	return nil
+}
+
+// DisableMFA removes a user's TOTP enrollment entirely. Admin-only: callers must check
+// ManageUsers/an equivalent permission before invoking this.
+func DisableMFA(userID int, db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM bo_user_mfa WHERE user_id=?", userID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, invalidating any previously issued ones.
+// Admin-only: callers must check ManageUsers/an equivalent permission before invoking this.
+func RegenerateRecoveryCodes(userID int, db *sql.DB) ([]string, error) {
+	recovery, hashedRecovery, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("UPDATE bo_user_mfa SET recovery_codes=? WHERE user_id=?", joinHashes(hashedRecovery), userID)
+	if err != nil {
+		return nil, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return recovery, nil
+}
+
+// RequireMFA reports whether a token's "mfa" claim satisfies a second-factor requirement, for
+// middleware guarding sensitive endpoints like ManageUsers or ManageAPI. Pass it the claims returned
+// by VerifyTokenClaims rather than re-parsing the JWT, so the kid lookup and blacklist check still
+// happen. Tokens issued via CreateToken carry "mfa": false; callers must mint tokens with
+// CreateTokenWithMFA(usertkn, true) once the user has passed VerifyTOTP or VerifyRecoveryCode.
+func RequireMFA(claims jwt.MapClaims) error {
+	if satisfied, ok := claims["mfa"].(bool); !ok || !satisfied {
+		return ErrMFARequired
+	}
+	return nil
+}
+
+// joinHashes stores recovery code hashes as a comma-separated column value, matching the repo's
+// preference for a single denormalized column over a join table for small, rarely-queried sets.
+func joinHashes(hashes []string) string {
+	return strings.Join(hashes, ",")
+}
+
+// splitHashes parses the comma-separated column value written by joinHashes.
+func splitHashes(stored string) []string {
+	if stored == "" {
+		return nil
+	}
+	return strings.Split(stored, ",")
+}
+
+// generateRecoveryCodes returns recoveryCodeCount fresh recovery codes along with their SHA-256
+// hashes, as used by both EnrollTOTP and RegenerateRecoveryCodes.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, 0, recoveryCodeCount)
+	hashes = make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newOpaqueToken()
+		if err != nil {
+			return nil, nil, err
+		}
+		code = code[:10]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func mfaEncryptionKey() ([]byte, error) {
+	key := []byte(os.Getenv("MFA_ENCRYPTION_KEY"))
+	if len(key) != 32 {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be 32 bytes")
+	}
+	return key, nil
+}
+
+func encryptMFASecret(secret string) (string, error) {
+	key, err := mfaEncryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func decryptMFASecret(encoded string) (string, error) {
+	key, err := mfaEncryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed MFA secret ciphertext")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "testing"
+
+func TestEncryptDecryptMFASecretRoundTrip(t *testing.T) {
+	t.Setenv("MFA_ENCRYPTION_KEY", "01234567890123456789012345678901"[:32])
+
+	secret := "JBSWY3DPEHPK3PXP"
+
+	encrypted, err := encryptMFASecret(secret)
+	if err != nil {
+		t.Fatalf("encryptMFASecret returned an error: %v", err)
+	}
+	if encrypted == secret {
+		t.Fatalf("expected the secret to be encrypted, got plaintext back")
+	}
+
+	decrypted, err := decryptMFASecret(encrypted)
+	if err != nil {
+		t.Fatalf("decryptMFASecret returned an error: %v", err)
+	}
+	if decrypted != secret {
+		t.Fatalf("expected %q, got %q", secret, decrypted)
+	}
+}
+
+func TestMFAEncryptionKeyRejectsWrongLength(t *testing.T) {
+	t.Setenv("MFA_ENCRYPTION_KEY", "too-short")
+
+	if _, err := encryptMFASecret("secret"); err == nil {
+		t.Fatalf("expected an error for a non-32-byte MFA_ENCRYPTION_KEY")
+	}
+}
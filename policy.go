@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Role is a named bundle of permissions that can be assigned to users, replacing one-off boolean
+// columns with a model that new capabilities can be added to without a schema change.
+type Role struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// legacyPermissionRoles maps each existing Permissions boolean to the policy permission string it is
+// seeded as, so MigrateLegacyPermissions can create equivalent roles without losing anyone's access.
+var legacyPermissionRoles = map[string]string{
+	"manage_tariff":           "tariff:manage",
+	"manage_permits":          "permits:manage",
+	"view_reports":            "reports:view",
+	"manage_locations":        "locations:manage",
+	"manage_users":            "users:manage",
+	"manage_contacts":         "contacts:manage",
+	"manage_customer_support": "customer_support:manage",
+	"view_api":                "api:view",
+	"manage_api":              "api:manage",
+	"banned_vehicles":         "banned_vehicles:manage",
+	"marketing":               "marketing:manage",
+}
+
+// CreateRole stores a new named role.
+func CreateRole(name string, db *sql.DB) (Role, error) {
+	res, err := db.Exec("INSERT INTO bo_roles (name) VALUES (?)", name)
+	if err != nil {
+		return Role{}, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Role{}, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return Role{ID: int(id), Name: name}, nil
+}
+
+// AddPermissionToRole grants permission (e.g. "tariff:manage" or "reports:view:location:42") to
+// roleID, creating the permission row if it does not already exist.
+func AddPermissionToRole(roleID int, permission string, db *sql.DB) error {
+	_, err := db.Exec("INSERT IGNORE INTO bo_permissions (name) VALUES (?)", permission)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	sqlS := "INSERT IGNORE INTO bo_role_permissions (role_id, permission_id) SELECT ?, id FROM bo_permissions WHERE name=?"
+	_, err = db.Exec(sqlS, roleID, permission)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return nil
+}
+
+// AssignRole grants roleID to userID.
+func AssignRole(userID, roleID int, db *sql.DB) error {
+	_, err := db.Exec("INSERT IGNORE INTO bo_user_roles (user_id, role_id) VALUES (?,?)", userID, roleID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return policyEngine().reload(userID, db)
+}
+
+// RevokeRole removes roleID from userID.
+func RevokeRole(userID, roleID int, db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM bo_user_roles WHERE user_id=? AND role_id=?", userID, roleID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return policyEngine().reload(userID, db)
+}
+
+// MigrateLegacyPermissions seeds a role per legacy Permissions boolean and assigns it to every user
+// who currently has that boolean set, so switching to the role/permission model does not silently
+// revoke anyone's existing access.
+func MigrateLegacyPermissions(db *sql.DB) error {
+	for column, permission := range legacyPermissionRoles {
+		role, err := CreateRole("legacy:"+column, db)
+		if err != nil {
+			return err
+		}
+
+		if err = AddPermissionToRole(role.ID, permission, db); err != nil {
+			return err
+		}
+
+		sqlS := fmt.Sprintf("SELECT usrid FROM bo_user_security WHERE %s=1", column)
+		rows, err := db.Query(sqlS)
+		if err != nil {
+			return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+
+		var userID int
+		for rows.Next() {
+			if err = rows.Scan(&userID); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+			}
+			if err = AssignRole(userID, role.ID, db); err != nil {
+				_ = rows.Close()
+				return err
+			}
+		}
+		_ = rows.Close()
+	}
+
+	return nil
+}
+
+// PolicyEngine evaluates User.Can against an in-memory cache of each user's granted permissions, so
+// authorization checks on the request path never need a database round trip.
+type PolicyEngine struct {
+	mu    sync.RWMutex
+	perms map[int][]string
+}
+
+func newPolicyEngine() *PolicyEngine {
+	return &PolicyEngine{perms: make(map[int][]string)}
+}
+
+// Load rebuilds the entire permission cache from bo_user_roles/bo_role_permissions/bo_permissions.
+func (p *PolicyEngine) Load(db *sql.DB) error {
+	sqlS := `SELECT ur.user_id, perm.name FROM bo_user_roles ur
+		JOIN bo_role_permissions rp ON rp.role_id = ur.role_id
+		JOIN bo_permissions perm ON perm.id = rp.permission_id`
+	rows, err := db.Query(sqlS)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	perms := make(map[int][]string)
+	for rows.Next() {
+		var userID int
+		var name string
+		if err = rows.Scan(&userID, &name); err != nil {
+			return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+		perms[userID] = append(perms[userID], name)
+	}
+
+	p.mu.Lock()
+	p.perms = perms
+	p.mu.Unlock()
+
+	return nil
+}
+
+// reload recomputes and caches userID's permissions from their currently assigned roles, so
+// AssignRole/RevokeRole take effect immediately without waiting for a full Load(db).
+func (p *PolicyEngine) reload(userID int, db *sql.DB) error {
+	sqlS := `SELECT perm.name FROM bo_user_roles ur
+		JOIN bo_role_permissions rp ON rp.role_id = ur.role_id
+		JOIN bo_permissions perm ON perm.id = rp.permission_id
+		WHERE ur.user_id=?`
+	rows, err := db.Query(sqlS, userID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	p.mu.Lock()
+	if len(names) == 0 {
+		delete(p.perms, userID)
+	} else {
+		p.perms[userID] = names
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Can reports whether userID holds a permission matching action and resource. Permission strings are
+// colon-delimited (e.g. "reports:view:location:42"); a granted permission matches a query if every
+// segment is equal or the granted segment is "*", and a granted permission with fewer segments than
+// the query matches as a prefix (so "reports:view" grants "reports:view:location:42").
+func (p *PolicyEngine) Can(userID int, action string, resource ...string) bool {
+	query := append(strings.Split(action, ":"), resource...)
+
+	p.mu.RLock()
+	granted := p.perms[userID]
+	p.mu.RUnlock()
+
+	for _, g := range granted {
+		if matchPermission(strings.Split(g, ":"), query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPermission(granted, query []string) bool {
+	if len(granted) > len(query) {
+		return false
+	}
+	for i, seg := range granted {
+		if seg == "*" {
+			continue
+		}
+		if seg != query[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	defaultPolicyOnce sync.Once
+	defaultPolicy     *PolicyEngine
+)
+
+func policyEngine() *PolicyEngine {
+	defaultPolicyOnce.Do(func() {
+		defaultPolicy = newPolicyEngine()
+	})
+	return defaultPolicy
+}
+
+// LoadPolicy refreshes the package-level policy cache used by User.Can and RequirePermission. Call it
+// once at startup and again after any AssignRole/RevokeRole/AddPermissionToRole outside of this
+// package's own helpers.
+func LoadPolicy(db *sql.DB) error {
+	return policyEngine().Load(db)
+}
+
+// Can reports whether the user holds a permission matching action and resource, e.g.
+// user.Can("reports:view", "location", "42"). It is backed by the package-level policy cache
+// populated by LoadPolicy.
+func (m *User) Can(action string, resource ...string) bool {
+	return policyEngine().Can(m.ID, action, resource...)
+}
+
+type contextKey string
+
+const userContextKey contextKey = "auth.user"
+
+// ContextWithUser returns a copy of ctx carrying usr, for use by RequirePermission.
+func ContextWithUser(ctx context.Context, usr *User) context.Context {
+	return context.WithValue(ctx, userContextKey, usr)
+}
+
+// UserFromContext retrieves the *User previously stored by ContextWithUser.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	usr, ok := ctx.Value(userContextKey).(*User)
+	return usr, ok
+}
+
+// RequirePermission returns HTTP middleware that responds 403 Forbidden unless the request's
+// context carries a *User (via ContextWithUser) that satisfies action/resource.
+func RequirePermission(action string, resource ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			usr, ok := UserFromContext(r.Context())
+			if !ok || !usr.Can(action, resource...) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package auth
+
+import "testing"
+
+func TestPolicyEngineCan(t *testing.T) {
+	p := newPolicyEngine()
+	p.perms[1] = []string{"reports:view", "tariff:*"}
+
+	cases := []struct {
+		action   string
+		resource []string
+		want     bool
+	}{
+		{"reports:view", nil, true},
+		{"reports:view", []string{"location", "42"}, true},
+		{"reports:manage", nil, false},
+		{"tariff:manage", nil, true},
+		{"users:manage", nil, false},
+	}
+
+	for _, c := range cases {
+		if got := p.Can(1, c.action, c.resource...); got != c.want {
+			t.Errorf("Can(1, %q, %v) = %v, want %v", c.action, c.resource, got, c.want)
+		}
+	}
+
+	if p.Can(2, "reports:view") {
+		t.Errorf("user with no cached permissions should never be granted access")
+	}
+}
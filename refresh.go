@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RefreshTokenTTL is the lifetime of an opaque refresh token issued by CreateTokenPair.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// TokenPair is the pair of credentials handed back to a client on login or refresh: a short-lived
+// JWT used to authenticate requests, and an opaque single-use token used only to obtain the next pair.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// CreateTokenPair issues a new access/refresh token pair for usertkn and records an EventLogin
+// audit entry. The access token is a normal CreateToken JWT; the refresh token is a random opaque
+// string whose SHA-256 hash is stored in bo_user_refresh_tokens so it can be rotated or revoked
+// without needing to decode a JWT.
+func CreateTokenPair(usertkn string, db *sql.DB) (TokenPair, error) {
+	return CreateTokenPairWithContext(usertkn, "", "", db)
+}
+
+// CreateTokenPairWithContext is CreateTokenPair with the requesting user agent/IP recorded against
+// the refresh token, the active session, and the login audit entry.
+func CreateTokenPairWithContext(usertkn, userAgent, ip string, db *sql.DB) (TokenPair, error) {
+	pair, jti, userID, err := createTokenPair(usertkn, 0, db, userAgent, ip)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err = RecordLoginEvent(userID, EventLogin, jti, ip, userAgent, true, "", db); err != nil {
+		log.Warnln(err)
+	}
+
+	return pair, nil
+}
+
+func createTokenPair(usertkn string, userID int, db *sql.DB, userAgent, ip string) (pair TokenPair, jti string, resolvedUserID int, err error) {
+	access, jti, err := createAccessToken(usertkn, false)
+	if err != nil {
+		return TokenPair{}, "", 0, err
+	}
+
+	refresh, err := newOpaqueToken()
+	if err != nil {
+		return TokenPair{}, "", 0, err
+	}
+
+	if userID == 0 {
+		usr := User{}
+		if err = usr.Get(usertkn, db); err != nil {
+			return TokenPair{}, "", 0, err
+		}
+		userID = usr.ID
+	}
+
+	issuedAt := time.Now()
+
+	sqlS := "INSERT INTO bo_user_refresh_tokens (id, user_id, token_hash, issued_at, expires_at, revoked, user_agent, ip) VALUES (?,?,?,NOW(),?,0,?,?)"
+	_, err = db.Exec(sqlS, uuid.NewString(), userID, hashToken(refresh), issuedAt.Add(RefreshTokenTTL), userAgent, ip)
+	if err != nil {
+		return TokenPair{}, "", 0, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	// Track the access token's jti in bo_active_tokens so RevokeAllForUser can blacklist every
+	// outstanding access token for this user, not just their refresh tokens, and so ListSessions
+	// has something to show. This is the same table the session flusher updates last_access on.
+	sqlS = "INSERT INTO bo_active_tokens (jti, user_id, issued_at, last_access, user_agent, ip) VALUES (?,?,?,?,?,?)"
+	_, err = db.Exec(sqlS, jti, userID, issuedAt, issuedAt, userAgent, ip)
+	if err != nil {
+		return TokenPair{}, "", 0, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return TokenPair{AccessToken: access, RefreshToken: refresh}, jti, userID, nil
+}
+
+// RefreshToken rotates refresh into a new TokenPair: the presented token is marked replaced and a
+// fresh pair is issued. If refresh has already been revoked (i.e. it was presented a second time),
+// this is treated as token theft and the entire refresh chain for that user is revoked via
+// RevokeAllForUser, so a stolen-and-reused token invalidates every session rather than just itself.
+func RefreshToken(refresh string, db *sql.DB) (TokenPair, error) {
+	return refreshToken(refresh, db, "", "")
+}
+
+func refreshToken(refresh string, db *sql.DB, userAgent, ip string) (TokenPair, error) {
+	hash := hashToken(refresh)
+
+	var id string
+	var userID int
+	var usertkn string
+	var revoked bool
+	var expiresAt time.Time
+
+	sqlS := "SELECT r.id, r.user_id, u.tkn, r.revoked, r.expires_at FROM bo_user_refresh_tokens r JOIN bo_user u ON u.id=r.user_id WHERE r.token_hash=?"
+	err := db.QueryRow(sqlS, hash).Scan(&id, &userID, &usertkn, &revoked, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TokenPair{}, ErrInvalidRefreshToken
+		}
+		return TokenPair{}, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	if revoked {
+		if err = RevokeAllForUser(userID, db); err != nil {
+			log.Warnln(err)
+		}
+		if err = RecordLoginEvent(userID, EventTokenRefresh, "", ip, userAgent, false, "refresh token reuse detected", db); err != nil {
+			log.Warnln(err)
+		}
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	if time.Now().After(expiresAt) {
+		if err = RecordLoginEvent(userID, EventTokenRefresh, "", ip, userAgent, false, "refresh token expired", db); err != nil {
+			log.Warnln(err)
+		}
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	pair, jti, _, err := createTokenPair(usertkn, userID, db, userAgent, ip)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	newHash := hashToken(pair.RefreshToken)
+	_, err = db.Exec("UPDATE bo_user_refresh_tokens SET revoked=1, replaced_by=? WHERE id=?", newHash, id)
+	if err != nil {
+		log.Warnln(err)
+	}
+
+	if err = RecordLoginEvent(userID, EventTokenRefresh, jti, ip, userAgent, true, "", db); err != nil {
+		log.Warnln(err)
+	}
+
+	return pair, nil
+}
+
+// Logout ends a session from the holder's own request: it revokes the access token identified by
+// jti (so VerifyToken rejects it immediately), removes it from bo_active_tokens, and records an
+// EventLogout audit entry.
+func Logout(userID int, jti, userAgent, ip string, db *sql.DB) error {
+	if err := RevokeSession(jti, db); err != nil {
+		return err
+	}
+
+	return RecordLoginEvent(userID, EventLogout, jti, ip, userAgent, true, "", db)
+}
+
+// RevokeToken blacklists a single access token by its "jti" claim, rejecting it on any subsequent
+// VerifyToken call even though the JWT itself has not expired.
+func RevokeToken(jti string, db *sql.DB) error {
+	_, err := db.Exec("INSERT INTO bo_token_blacklist (jti, revoked_at) VALUES (?,NOW())", jti)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return nil
+}
+
+// RevokeAllForUser logs a user out of every session: it revokes all of the user's outstanding
+// refresh tokens and blacklists the jti of every access token recorded for them in
+// bo_active_tokens (populated by createTokenPair on issue), so those access tokens are rejected by
+// VerifyToken immediately rather than simply expiring after AccessTokenTTL.
+func RevokeAllForUser(userID int, db *sql.DB) error {
+	_, err := db.Exec("UPDATE bo_user_refresh_tokens SET revoked=1 WHERE user_id=? AND revoked=0", userID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	rows, err := db.Query("SELECT jti FROM bo_active_tokens WHERE user_id=?", userID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	jtis := make([]string, 0)
+	for rows.Next() {
+		var jti string
+		if err = rows.Scan(&jti); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+		jtis = append(jtis, jti)
+	}
+	_ = rows.Close()
+
+	for _, jti := range jtis {
+		if err = RevokeToken(jti, db); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec("DELETE FROM bo_active_tokens WHERE user_id=?", userID)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return nil
+}
+
+// isTokenBlacklisted reports whether jti has been revoked via RevokeToken/RevokeAllForUser.
+func isTokenBlacklisted(jti string, db *sql.DB) (bool, error) {
+	var exists int
+	err := db.QueryRow("SELECT 1 FROM bo_token_blacklist WHERE jti=?", jti).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+	return exists == 1, nil
+}
+
+// newOpaqueToken generates a random, URL-safe opaque refresh token that is never stored in plaintext.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 hash of an opaque token, as stored in bo_user_refresh_tokens.token_hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,36 @@
+package auth
+
+import "testing"
+
+func TestHashTokenDeterministic(t *testing.T) {
+	a := hashToken("the-refresh-token")
+	b := hashToken("the-refresh-token")
+	if a != b {
+		t.Fatalf("hashToken should be deterministic, got %q and %q", a, b)
+	}
+
+	if c := hashToken("a-different-token"); c == a {
+		t.Fatalf("hashToken should not collide for different inputs")
+	}
+}
+
+func TestNewOpaqueTokenUnique(t *testing.T) {
+	a, err := newOpaqueToken()
+	if err != nil {
+		t.Fatalf("newOpaqueToken returned an error: %v", err)
+	}
+	b, err := newOpaqueToken()
+	if err != nil {
+		t.Fatalf("newOpaqueToken returned an error: %v", err)
+	}
+
+	if a == b {
+		t.Fatalf("expected two calls to newOpaqueToken to produce different tokens")
+	}
+
+	// A stolen-and-reused refresh token is detected by comparing hashes, so the hash stored at
+	// issuance must still match the same token's hash at reuse time.
+	if hashToken(a) != hashToken(a) {
+		t.Fatalf("hashToken of the same opaque token should match itself across calls")
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var ErrRegistrationTokenInvalid = errors.New("registration token is invalid, expired or exhausted")
+
+const registrationTokenAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// RegistrationToken is an admin-issued, single- or multi-use token that lets a new user register
+// without an account having been pre-provisioned for them, modelled on Matrix's
+// m.login.registration_token flow.
+type RegistrationToken struct {
+	Token         string     `json:"token"`
+	UsesAllowed   *int32     `json:"uses_allowed"`
+	UsesRemaining int32      `json:"uses_remaining"`
+	ExpiryTime    *time.Time `json:"expiry_time"`
+	CreatedBy     int        `json:"created_by"`
+	Pending       bool       `json:"pending"`
+}
+
+// GenerateRegistrationTokenString returns a random alphanumeric token of the given length, suitable
+// for passing as the token argument to CreateRegistrationToken.
+func GenerateRegistrationTokenString(length int32) (string, error) {
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(registrationTokenAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = registrationTokenAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// CreateRegistrationToken stores a new registration token. uses is the number of times the token may
+// be redeemed (0 means unlimited), expiresAt is the time after which the token is no longer valid
+// (the zero value means it never expires), length is only used when token is empty, in which case a
+// random token of that length is generated.
+func CreateRegistrationToken(uses int32, expiresAt time.Time, length int32, token string, createdBy int, db *sql.DB) (RegistrationToken, error) {
+	if token == "" {
+		var err error
+		token, err = GenerateRegistrationTokenString(length)
+		if err != nil {
+			return RegistrationToken{}, err
+		}
+	}
+
+	var usesAllowed sql.NullInt32
+	if uses > 0 {
+		usesAllowed = sql.NullInt32{Int32: uses, Valid: true}
+	}
+
+	var expiry sql.NullTime
+	if !expiresAt.IsZero() {
+		expiry = sql.NullTime{Time: expiresAt, Valid: true}
+	}
+
+	sqlS := "INSERT INTO bo_registration_tokens (token, uses_allowed, uses_remaining, expiry_time, created_by, pending) VALUES (?,?,?,?,?,1)"
+	_, err := db.Exec(sqlS, token, usesAllowed, uses, expiry, createdBy)
+	if err != nil {
+		return RegistrationToken{}, fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+
+	return GetRegistrationToken(token, db)
+}
+
+// ValidateRegistrationToken atomically decrements uses_remaining for token, rejecting it with
+// ErrRegistrationTokenInvalid if it does not exist, has expired, or has no uses remaining.
+func ValidateRegistrationToken(token string, db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var usesAllowed sql.NullInt32
+	var usesRemaining int32
+	var expiry sql.NullTime
+
+	sqlS := "SELECT uses_allowed, uses_remaining, expiry_time FROM bo_registration_tokens WHERE token=? FOR UPDATE"
+	err = tx.QueryRow(sqlS, token).Scan(&usesAllowed, &usesRemaining, &expiry)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRegistrationTokenInvalid
+		}
+		return fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	if expiry.Valid && time.Now().After(expiry.Time) {
+		return ErrRegistrationTokenInvalid
+	}
+
+	if usesAllowed.Valid && usesRemaining <= 0 {
+		return ErrRegistrationTokenInvalid
+	}
+
+	if usesAllowed.Valid {
+		_, err = tx.Exec("UPDATE bo_registration_tokens SET uses_remaining=uses_remaining-1 WHERE token=?", token)
+		if err != nil {
+			return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRegistrationToken retrieves a single registration token by its value.
+func GetRegistrationToken(token string, db *sql.DB) (RegistrationToken, error) {
+	rt := RegistrationToken{}
+	var usesAllowed sql.NullInt32
+	var expiry sql.NullTime
+
+	sqlS := "SELECT token, uses_allowed, uses_remaining, expiry_time, created_by, pending FROM bo_registration_tokens WHERE token=?"
+	err := db.QueryRow(sqlS, token).Scan(&rt.Token, &usesAllowed, &rt.UsesRemaining, &expiry, &rt.CreatedBy, &rt.Pending)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rt, ErrRegistrationTokenInvalid
+		}
+		return rt, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+
+	if usesAllowed.Valid {
+		rt.UsesAllowed = &usesAllowed.Int32
+	}
+	if expiry.Valid {
+		rt.ExpiryTime = &expiry.Time
+	}
+
+	return rt, nil
+}
+
+// ListRegistrationTokens returns every registration token that has been issued.
+func ListRegistrationTokens(db *sql.DB) ([]RegistrationToken, error) {
+	tokens := make([]RegistrationToken, 0)
+
+	sqlS := "SELECT token, uses_allowed, uses_remaining, expiry_time, created_by, pending FROM bo_registration_tokens"
+	rows, err := db.Query(sqlS)
+	if err != nil {
+		return tokens, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		rt := RegistrationToken{}
+		var usesAllowed sql.NullInt32
+		var expiry sql.NullTime
+
+		err = rows.Scan(&rt.Token, &usesAllowed, &rt.UsesRemaining, &expiry, &rt.CreatedBy, &rt.Pending)
+		if err != nil {
+			return tokens, fmt.Errorf("there was an unexpected error reading from the database: %v", err)
+		}
+
+		if usesAllowed.Valid {
+			rt.UsesAllowed = &usesAllowed.Int32
+		}
+		if expiry.Valid {
+			rt.ExpiryTime = &expiry.Time
+		}
+
+		tokens = append(tokens, rt)
+	}
+
+	return tokens, nil
+}
+
+// UpdateRegistrationToken updates the pending state and expiry of an existing registration token.
+func UpdateRegistrationToken(token string, pending bool, expiresAt time.Time, db *sql.DB) error {
+	var expiry sql.NullTime
+	if !expiresAt.IsZero() {
+		expiry = sql.NullTime{Time: expiresAt, Valid: true}
+	}
+
+	_, err := db.Exec("UPDATE bo_registration_tokens SET pending=?, expiry_time=? WHERE token=?", pending, expiry, token)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return nil
+}
+
+// DeleteRegistrationToken permanently removes a registration token, e.g. once an admin wants to
+// revoke an unused invite.
+func DeleteRegistrationToken(token string, db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM bo_registration_tokens WHERE token=?", token)
+	if err != nil {
+		return fmt.Errorf("there was an unexpected error writing to the database: %v", err)
+	}
+	return nil
+}
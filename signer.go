@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// Algorithm identifies which JWT signing algorithm a Key uses.
+type Algorithm string
+
+const (
+	AlgHS256  Algorithm = "HS256"
+	AlgRS256  Algorithm = "RS256"
+	AlgEdDSA  Algorithm = "EdDSA"
+	keyGrace            = 7 * 24 * time.Hour
+)
+
+var ErrUnknownKID = errors.New("no key found for token kid")
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// Key is one signing/verification key tracked by a KeyStore. SigningKey is the private half used to
+// sign new tokens (nil for keys that are kept only to verify tokens issued before rotation).
+// VerifyKey is the public (or, for HS256, shared secret) half used to check a signature.
+// RetiredAt is set once a key is no longer used for signing; it remains valid for verification until
+// keyGrace has elapsed, after which VerifyToken will reject tokens bearing its kid.
+type Key struct {
+	KID        string
+	Alg        Algorithm
+	SigningKey interface{}
+	VerifyKey  interface{}
+	RetiredAt  time.Time
+}
+
+func (k *Key) expired() bool {
+	return !k.RetiredAt.IsZero() && time.Now().After(k.RetiredAt.Add(keyGrace))
+}
+
+func (k *Key) signingMethod() jwt.SigningMethod {
+	switch k.Alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// KeyStore tracks the set of keys a Signer may sign or verify with, and lets callers rotate the
+// active signing key without invalidating tokens that were issued under an older one.
+type KeyStore interface {
+	// ActiveKey returns the key new tokens should be signed with.
+	ActiveKey() (*Key, error)
+	// Key returns the key identified by kid, including retired (grace-period) keys.
+	Key(kid string) (*Key, error)
+	// RotateKey makes next the active signing key, retiring the current one into its grace period.
+	RotateKey(next *Key)
+}
+
+// MemoryKeyStore is a KeyStore backed by an in-memory map, suitable for keys loaded from files,
+// environment variables, or any other source at process startup.
+type MemoryKeyStore struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active string
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]*Key)}
+}
+
+// AddKey registers key in the store. If no active key is set yet, key becomes active.
+func (s *MemoryKeyStore) AddKey(key *Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.KID] = key
+	if s.active == "" {
+		s.active = key.KID
+	}
+}
+
+func (s *MemoryKeyStore) ActiveKey() (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[s.active]
+	if !ok {
+		return nil, ErrUnknownKID
+	}
+	return key, nil
+}
+
+func (s *MemoryKeyStore) Key(kid string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+	if !ok || key.expired() {
+		return nil, ErrUnknownKID
+	}
+	return key, nil
+}
+
+func (s *MemoryKeyStore) RotateKey(next *Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.keys[s.active]; ok {
+		cur.RetiredAt = time.Now()
+	}
+	s.keys[next.KID] = next
+	s.active = next.KID
+}
+
+// defaultKeyStore backs the package-level CreateToken/VerifyToken functions so existing callers keep
+// working unchanged. It is seeded lazily from SECRET_KEY to preserve the original HS256 behaviour.
+var (
+	defaultKeyStoreOnce sync.Once
+	defaultKeyStore     KeyStore
+)
+
+// SetKeyStore overrides the package-level KeyStore used by CreateToken/VerifyToken, e.g. to switch to
+// RS256/EdDSA keys loaded from files instead of the SECRET_KEY environment variable.
+func SetKeyStore(ks KeyStore) {
+	defaultKeyStore = ks
+}
+
+func keyStore() KeyStore {
+	defaultKeyStoreOnce.Do(func() {
+		if defaultKeyStore == nil {
+			ks := NewMemoryKeyStore()
+			ks.AddKey(&Key{
+				KID:        "default",
+				Alg:        AlgHS256,
+				SigningKey: []byte(os.Getenv("SECRET_KEY")),
+				VerifyKey:  []byte(os.Getenv("SECRET_KEY")),
+			})
+			defaultKeyStore = ks
+		}
+	})
+	return defaultKeyStore
+}
+
+// RotateKey promotes a newly generated key to be the signing key for future tokens, while the
+// previously active key remains valid for VerifyToken until its grace period expires. This lets
+// services roll keys without invalidating tokens that are still in flight.
+func RotateKey(key *Key) {
+	keyStore().RotateKey(key)
+}
+
+// JWKS is the subset of RFC 7517 fields downstream services need to verify RS256/EdDSA tokens
+// without sharing a secret. HS256 keys are never included since their "key" is a shared secret.
+type JWKSDocument struct {
+	Keys []JWK `json:"keys"`
+}
+
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Crv string `json:"crv,omitempty"`
+}
+
+// currentJWKS renders every non-expired asymmetric key in ks as a JWKS document.
+func currentJWKS(ks KeyStore) JWKSDocument {
+	mks, ok := ks.(*MemoryKeyStore)
+	if !ok {
+		return JWKSDocument{}
+	}
+
+	mks.mu.RLock()
+	defer mks.mu.RUnlock()
+
+	out := JWKSDocument{Keys: make([]JWK, 0, len(mks.keys))}
+	for _, k := range mks.keys {
+		if k.expired() {
+			continue
+		}
+		switch pub := k.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			out.Keys = append(out.Keys, JWK{Kid: k.KID, Kty: "RSA", Alg: string(AlgRS256), N: pub.N.String(), E: fmt.Sprintf("%d", pub.E)})
+		case ed25519.PublicKey:
+			out.Keys = append(out.Keys, JWK{Kid: k.KID, Kty: "OKP", Alg: string(AlgEdDSA), Crv: "Ed25519", X: string(pub)})
+		}
+	}
+	return out
+}
+
+// JWKS returns the public keys of every non-expired asymmetric key currently tracked by the
+// package-level KeyStore, shaped for serving at a jwks.json endpoint.
+func JWKS() JWKSDocument {
+	return currentJWKS(keyStore())
+}
+
+// newKID returns a fresh, unique key identifier for use with RotateKey.
+func newKID() string {
+	return uuid.NewString()
+}
+
+// NewHS256Key wraps a shared secret (e.g. loaded from SECRET_KEY) as a signing/verification Key.
+func NewHS256Key(kid string, secret []byte) *Key {
+	return &Key{KID: kid, Alg: AlgHS256, SigningKey: secret, VerifyKey: secret}
+}
+
+// NewRS256Key wraps an RSA key pair as a signing/verification Key.
+func NewRS256Key(kid string, priv *rsa.PrivateKey) *Key {
+	return &Key{KID: kid, Alg: AlgRS256, SigningKey: priv, VerifyKey: &priv.PublicKey}
+}
+
+// NewEdDSAKey wraps an Ed25519 key pair as a signing/verification Key.
+func NewEdDSAKey(kid string, priv ed25519.PrivateKey) *Key {
+	return &Key{KID: kid, Alg: AlgEdDSA, SigningKey: priv, VerifyKey: priv.Public()}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyExpired(t *testing.T) {
+	active := &Key{KID: "active", Alg: AlgHS256}
+	if active.expired() {
+		t.Fatalf("a key with no RetiredAt should never be expired")
+	}
+
+	withinGrace := &Key{KID: "retired-recent", Alg: AlgHS256, RetiredAt: time.Now().Add(-keyGrace / 2)}
+	if withinGrace.expired() {
+		t.Fatalf("a key retired less than keyGrace ago should still be valid")
+	}
+
+	pastGrace := &Key{KID: "retired-old", Alg: AlgHS256, RetiredAt: time.Now().Add(-keyGrace * 2)}
+	if !pastGrace.expired() {
+		t.Fatalf("a key retired more than keyGrace ago should be expired")
+	}
+}
+
+func TestMemoryKeyStoreRotateKey(t *testing.T) {
+	ks := NewMemoryKeyStore()
+	oldKey := NewHS256Key("old", []byte("old-secret"))
+	ks.AddKey(oldKey)
+
+	active, err := ks.ActiveKey()
+	if err != nil || active.KID != "old" {
+		t.Fatalf("expected old to be the active key, got %v, err %v", active, err)
+	}
+
+	newKey := NewHS256Key("new", []byte("new-secret"))
+	ks.RotateKey(newKey)
+
+	active, err = ks.ActiveKey()
+	if err != nil || active.KID != "new" {
+		t.Fatalf("expected new to be the active key after rotation, got %v, err %v", active, err)
+	}
+
+	if _, err = ks.Key("old"); err != nil {
+		t.Fatalf("retired key should still verify within its grace period: %v", err)
+	}
+
+	oldKey.RetiredAt = time.Now().Add(-keyGrace * 2)
+	if _, err = ks.Key("old"); err == nil {
+		t.Fatalf("expected ErrUnknownKID once the retired key is past its grace period")
+	}
+}
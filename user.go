@@ -12,18 +12,19 @@ var ErrUserInActive = errors.New("user account is inactive")
 var ErrInvalidUserToken = errors.New("invalid User Token")
 
 type Permissions struct {
-	ManageTariff          bool `json:"manage_tariff"`
-	ManagePermits         bool `json:"manage_permits"`
-	ViewReports           bool `json:"view_reports"`
-	ManageLocations       bool `json:"manage_locations"`
-	ManageUsers           bool `json:"manage_users"`
-	ManageContacts        bool `json:"manage_contacts"`
-	ManageCustomerSupport bool `json:"manage_customer_support"`
-	ViewAPI               bool `json:"view_api"`
-	ManageAPI             bool `json:"manage_api"`
-	BannedVehicles        bool `json:"banned_vehicles"`
-	Marketing             bool `json:"marketing"`
-	ExportData            bool `json:"export_data"`
+	ManageTariff             bool `json:"manage_tariff"`
+	ManagePermits            bool `json:"manage_permits"`
+	ViewReports              bool `json:"view_reports"`
+	ManageLocations          bool `json:"manage_locations"`
+	ManageUsers              bool `json:"manage_users"`
+	ManageContacts           bool `json:"manage_contacts"`
+	ManageCustomerSupport    bool `json:"manage_customer_support"`
+	ViewAPI                  bool `json:"view_api"`
+	ManageAPI                bool `json:"manage_api"`
+	BannedVehicles           bool `json:"banned_vehicles"`
+	Marketing                bool `json:"marketing"`
+	ExportData               bool `json:"export_data"`
+	ManageRegistrationTokens bool `json:"manage_registration_tokens"`
 }
 
 // User represents a user in the system. It contains information such as ID, name, email, activity status, last login, entered date, entered by, token, password expiry date, permissions
@@ -41,6 +42,7 @@ type User struct {
 	ClientId           int            `json:"clientid"`
 	Locations          map[int]string `json:"locations"`
 	UserType           string         `json:"user_type"`
+	MFAEnabled         bool           `json:"mfa_enabled"`
 }
 
 // Get retrieves user information from the database based on the provided user token.
@@ -62,7 +64,11 @@ type User struct {
 // Additionally, the function retrieves the user's locations from the "bo_user_locations" table and stores them in
 // the "Locations" field of the User struct.
 //
-// Finally, the function updates the "last_login" field of the "bo_user" table with the current timestamp.
+// Get no longer touches "last_login" itself: looking a token up happens on every authenticated
+// request, not just at login, so that would conflate per-request activity with actual
+// authentication events. "last_login" is now updated by RecordLoginEvent when a real login event
+// is recorded, and session-level activity is tracked separately per token in bo_active_tokens (see
+// ListSessions/SessionFlusher).
 //
 // Usage:
 //
@@ -103,8 +109,8 @@ func (m *User) Get(userTkn string, db *sql.DB) error {
 	// User Permissions
 	// ------------------------------------------------------------------------
 
-	sqlS = "SELECT manage_tariff, manage_permits, view_reports, manage_locations, manage_users, manage_contacts, manage_customer_support, view_api, manage_api,banned_vehicles,marketing FROM bo_user_security WHERE usrid=?"
-	err = db.QueryRow(sqlS, m.ID).Scan(&m.Permissions.ManageTariff, &m.Permissions.ManagePermits, &m.Permissions.ViewReports, &m.Permissions.ManageLocations, &m.Permissions.ManageUsers, &m.Permissions.ManageContacts, &m.Permissions.ManageCustomerSupport, &m.Permissions.ViewAPI, &m.Permissions.ManageAPI, &m.Permissions.BannedVehicles, &m.Permissions.Marketing)
+	sqlS = "SELECT manage_tariff, manage_permits, view_reports, manage_locations, manage_users, manage_contacts, manage_customer_support, view_api, manage_api,banned_vehicles,marketing,manage_registration_tokens FROM bo_user_security WHERE usrid=?"
+	err = db.QueryRow(sqlS, m.ID).Scan(&m.Permissions.ManageTariff, &m.Permissions.ManagePermits, &m.Permissions.ViewReports, &m.Permissions.ManageLocations, &m.Permissions.ManageUsers, &m.Permissions.ManageContacts, &m.Permissions.ManageCustomerSupport, &m.Permissions.ViewAPI, &m.Permissions.ManageAPI, &m.Permissions.BannedVehicles, &m.Permissions.Marketing, &m.Permissions.ManageRegistrationTokens)
 	if err != nil {
 		log.Warnln(err)
 	}
@@ -134,11 +140,13 @@ func (m *User) Get(userTkn string, db *sql.DB) error {
 		_ = rows.Close()
 	}
 	// ------------------------------------------------------------------------
-
-	_, err = db.Exec("UPDATE bo_user SET last_login=NOW() WHERE id=?", m.ID)
-	if err != nil {
+	// MFA status
+	// ------------------------------------------------------------------------
+	err = db.QueryRow("SELECT enabled FROM bo_user_mfa WHERE user_id=?", m.ID).Scan(&m.MFAEnabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Warnln(err)
 	}
+	// ------------------------------------------------------------------------
 
 	return nil
 }